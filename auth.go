@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// APIKeyInfo is everything the proxy knows about one issued API key.
+type APIKeyInfo struct {
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	RPMLimit int    `json:"rpm_limit"`
+	TPMLimit int    `json:"tpm_limit"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
+// KeyStore resolves a bearer token to the key identity that owns it.
+type KeyStore interface {
+	Lookup(key string) (APIKeyInfo, bool)
+}
+
+// staticKeyStore serves keys loaded once at startup, used by both the env-var
+// and file-backed stores.
+type staticKeyStore struct {
+	keys map[string]APIKeyInfo
+}
+
+func (s *staticKeyStore) Lookup(key string) (APIKeyInfo, bool) {
+	info, ok := s.keys[key]
+	return info, ok
+}
+
+const (
+	defaultRPMLimit = 60
+	defaultTPMLimit = 100_000
+)
+
+// NewEnvKeyStore builds a KeyStore from a comma-separated list of API keys in
+// the named environment variable, e.g. API_KEYS="sk-abc,sk-def". Keys loaded
+// this way get the default rate limits.
+func NewEnvKeyStore(envVar string) (KeyStore, error) {
+	raw := os.Getenv(envVar)
+	if raw == "" {
+		return nil, fmt.Errorf("environment variable %s is empty", envVar)
+	}
+
+	keys := make(map[string]APIKeyInfo)
+	for _, key := range strings.Split(raw, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		keys[key] = APIKeyInfo{Key: key, Name: key, RPMLimit: defaultRPMLimit, TPMLimit: defaultTPMLimit}
+	}
+
+	return &staticKeyStore{keys: keys}, nil
+}
+
+// NewFileKeyStore builds a KeyStore from a JSON file containing an array of
+// APIKeyInfo, letting operators set per-key names and quotas.
+func NewFileKeyStore(path string) (KeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %s: %w", path, err)
+	}
+
+	var entries []APIKeyInfo
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+
+	keys := make(map[string]APIKeyInfo, len(entries))
+	for _, entry := range entries {
+		if entry.RPMLimit == 0 {
+			entry.RPMLimit = defaultRPMLimit
+		}
+		if entry.TPMLimit == 0 {
+			entry.TPMLimit = defaultTPMLimit
+		}
+		keys[entry.Key] = entry
+	}
+
+	return &staticKeyStore{keys: keys}, nil
+}
+
+// sqliteKeyStore looks keys up against a `api_keys(key, name, rpm_limit,
+// tpm_limit, is_admin)` table, for deployments that rotate keys without a
+// redeploy.
+type sqliteKeyStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteKeyStore opens a SQLite database at dsn and serves key lookups
+// from its api_keys table.
+func NewSQLiteKeyStore(dsn string) (KeyStore, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key store db %s: %w", dsn, err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to key store db %s: %w", dsn, err)
+	}
+	return &sqliteKeyStore{db: db}, nil
+}
+
+func (s *sqliteKeyStore) Lookup(key string) (APIKeyInfo, bool) {
+	var info APIKeyInfo
+	row := s.db.QueryRow(
+		"SELECT key, name, rpm_limit, tpm_limit, is_admin FROM api_keys WHERE key = ?", key)
+	if err := row.Scan(&info.Key, &info.Name, &info.RPMLimit, &info.TPMLimit, &info.IsAdmin); err != nil {
+		return APIKeyInfo{}, false
+	}
+	return info, true
+}
+
+// keyLimiter bundles the per-key request-rate and token-rate budgets. RPM is
+// enforced with a standard token bucket; TPM is tracked the same way but
+// drained after the fact, once the actual token usage for a request is known.
+type keyLimiter struct {
+	requests *rate.Limiter
+	tokens   *rate.Limiter
+}
+
+func newKeyLimiter(info APIKeyInfo) *keyLimiter {
+	rpm := info.RPMLimit
+	if rpm <= 0 {
+		rpm = defaultRPMLimit
+	}
+	tpm := info.TPMLimit
+	if tpm <= 0 {
+		tpm = defaultTPMLimit
+	}
+
+	return &keyLimiter{
+		requests: rate.NewLimiter(rate.Limit(float64(rpm)/60), rpm),
+		tokens:   rate.NewLimiter(rate.Limit(float64(tpm)/60), tpm),
+	}
+}
+
+// RateLimiter hands out a keyLimiter per API key, creating it on first use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*keyLimiter
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{limiters: make(map[string]*keyLimiter)}
+}
+
+func (r *RateLimiter) limiterFor(info APIKeyInfo) *keyLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limiter, ok := r.limiters[info.Key]
+	if !ok {
+		limiter = newKeyLimiter(info)
+		r.limiters[info.Key] = limiter
+	}
+	return limiter
+}
+
+// AllowRequest reports whether a new request from this key is within both
+// its RPM budget and its TPM budget. The TPM check is a non-consuming peek
+// at the token bucket: a key that has already burned through its quota (via
+// ChargeTokens on prior responses) is rejected here before we spend another
+// upstream call on it; actual consumption happens in ChargeTokens once the
+// real usage for the request is known.
+func (r *RateLimiter) AllowRequest(info APIKeyInfo) bool {
+	limiter := r.limiterFor(info)
+	if !limiter.requests.Allow() {
+		return false
+	}
+	return limiter.tokens.Tokens() > 0
+}
+
+// ChargeTokens drains n tokens from the key's TPM budget. It is applied after
+// the fact (we only learn token counts once Vertex responds), so it caps
+// sustained throughput rather than any single request. ReserveN is used so
+// the bucket can go negative when fewer than n tokens remain. n is clamped to
+// the bucket's burst size: rate.Limiter.ReserveN refuses to apply any
+// change at all when n exceeds the burst, which would let a single
+// over-quota request drain nothing and repeat forever with no TPM
+// enforcement.
+func (r *RateLimiter) ChargeTokens(info APIKeyInfo, n int) {
+	if n <= 0 {
+		return
+	}
+	limiter := r.limiterFor(info)
+	if burst := limiter.tokens.Burst(); n > burst {
+		n = burst
+	}
+	limiter.tokens.ReserveN(time.Now(), n)
+}
+
+// KeyUsage accumulates token and request counters for one API key.
+type KeyUsage struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+// UsageTracker records per-key usage for the /admin/usage endpoint, alongside
+// the Prometheus counters recorded on the same calls.
+type UsageTracker struct {
+	mu    sync.Mutex
+	usage map[string]*KeyUsage
+}
+
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{usage: make(map[string]*KeyUsage)}
+}
+
+func (t *UsageTracker) Record(info APIKeyInfo, promptTokens, completionTokens int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.usage[info.Key]
+	if !ok {
+		entry = &KeyUsage{}
+		t.usage[info.Key] = entry
+	}
+	entry.Requests++
+	entry.PromptTokens += int64(promptTokens)
+	entry.CompletionTokens += int64(completionTokens)
+
+	requestsTotal.WithLabelValues(info.Name).Inc()
+	promptTokensTotal.WithLabelValues(info.Name).Add(float64(promptTokens))
+	completionTokensTotal.WithLabelValues(info.Name).Add(float64(completionTokens))
+}
+
+func (t *UsageTracker) Snapshot() map[string]KeyUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := make(map[string]KeyUsage, len(t.usage))
+	for key, usage := range t.usage {
+		snapshot[key] = *usage
+	}
+	return snapshot
+}
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_proxy_requests_total",
+		Help: "Total completed upstream requests, by API key name.",
+	}, []string{"api_key"})
+
+	promptTokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_proxy_prompt_tokens_total",
+		Help: "Total prompt tokens billed, by API key name.",
+	}, []string{"api_key"})
+
+	completionTokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_proxy_completion_tokens_total",
+		Help: "Total completion tokens billed, by API key name.",
+	}, []string{"api_key"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, promptTokensTotal, completionTokensTotal)
+}
+
+// loadKeyStore picks a KeyStore backend from the environment: a SQLite DSN
+// takes priority, then a JSON key file, then a plain comma-separated list.
+func loadKeyStore() (KeyStore, error) {
+	if dsn := os.Getenv("API_KEYS_SQLITE_DSN"); dsn != "" {
+		return NewSQLiteKeyStore(dsn)
+	}
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		return NewFileKeyStore(path)
+	}
+	return NewEnvKeyStore("API_KEYS")
+}
+
+const apiKeyContextKey = "apiKeyInfo"
+
+// apiKeyAuthMiddleware validates the Authorization bearer token against
+// s.keyStore and enforces that key's RPM budget before letting the request
+// through to a handler.
+func (s *Server) apiKeyAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == "" || token == header {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": gin.H{
+					"message": "Missing or malformed Authorization header",
+					"type":    "invalid_request_error",
+					"code":    "invalid_api_key",
+				},
+			})
+			return
+		}
+
+		info, exists := s.keyStore.Lookup(token)
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": gin.H{
+					"message": "Incorrect API key provided",
+					"type":    "invalid_request_error",
+					"code":    "invalid_api_key",
+				},
+			})
+			return
+		}
+
+		if !s.rateLimiter.AllowRequest(info) {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": gin.H{
+					"message": "Rate limit exceeded for this API key",
+					"type":    "rate_limit_error",
+					"code":    "rate_limit_exceeded",
+				},
+			})
+			return
+		}
+
+		c.Set(apiKeyContextKey, info)
+		c.Next()
+	}
+}
+
+// apiKeyFromContext retrieves the key identity attached by apiKeyAuthMiddleware.
+func apiKeyFromContext(c *gin.Context) (APIKeyInfo, bool) {
+	value, exists := c.Get(apiKeyContextKey)
+	if !exists {
+		return APIKeyInfo{}, false
+	}
+	info, ok := value.(APIKeyInfo)
+	return info, ok
+}
+
+// recordUsage charges the request's token usage against the caller's TPM
+// budget and records it for /admin/usage and Prometheus.
+func (s *Server) recordUsage(c *gin.Context, promptTokens, completionTokens int) {
+	info, ok := apiKeyFromContext(c)
+	if !ok {
+		return
+	}
+	s.rateLimiter.ChargeTokens(info, promptTokens+completionTokens)
+	s.usage.Record(info, promptTokens, completionTokens)
+}
+
+// handleAdminUsage reports accumulated per-key usage. It is mounted behind
+// apiKeyAuthMiddleware and restricted to admin keys.
+func (s *Server) handleAdminUsage(c *gin.Context) {
+	info, ok := apiKeyFromContext(c)
+	if !ok || !info.IsAdmin {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": gin.H{
+				"message": "This endpoint requires an admin API key",
+				"type":    "invalid_request_error",
+				"code":    "insufficient_permissions",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"usage": s.usage.Snapshot()})
+}
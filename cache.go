@@ -0,0 +1,289 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ResponseCache stores and retrieves full chat completion responses by a
+// cache key built from the request's deterministic fields (see cacheKey).
+type ResponseCache interface {
+	Get(ctx context.Context, key string) (openai.ChatCompletionResponse, bool)
+	Set(ctx context.Context, key string, resp openai.ChatCompletionResponse)
+}
+
+// cacheableRequest is the subset of a chat completion request that
+// deterministically identifies its output, used as the cache key.
+type cacheableRequest struct {
+	Model       string                         `json:"model"`
+	Messages    []openai.ChatCompletionMessage `json:"messages"`
+	Temperature float32                        `json:"temperature"`
+	TopP        float32                        `json:"top_p"`
+	MaxTokens   int                            `json:"max_tokens"`
+	Tools       []openai.Tool                  `json:"tools,omitempty"`
+	Seed        *int                           `json:"seed,omitempty"`
+}
+
+// cacheKey hashes the deterministic fields of request into a stable cache key.
+func cacheKey(request openai.ChatCompletionRequest) string {
+	cacheable := cacheableRequest{
+		Model:       request.Model,
+		Messages:    request.Messages,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		MaxTokens:   request.MaxTokens,
+		Tools:       request.Tools,
+		Seed:        request.Seed,
+	}
+
+	// Marshaling can't fail for this struct; ignore the error.
+	data, _ := json.Marshal(cacheable)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// shouldUseCache decides whether a request is eligible for the response
+// cache. A request's own Temperature field can't be used for this: OpenAI
+// clients that omit temperature entirely unmarshal to the same zero value as
+// a caller who explicitly asked for temperature 0, and the former is the
+// common case for ordinary non-deterministic chat traffic. So caching is
+// opt-in only, via the X-Cache: prefer header.
+func shouldUseCache(cacheHeader string) bool {
+	return cacheHeader == "prefer"
+}
+
+type lruEntry struct {
+	key   string
+	value openai.ChatCompletionResponse
+}
+
+// InMemoryLRUCache is a fixed-capacity, in-process ResponseCache.
+type InMemoryLRUCache struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func NewInMemoryLRUCache(capacity int) *InMemoryLRUCache {
+	return &InMemoryLRUCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *InMemoryLRUCache) Get(_ context.Context, key string) (openai.ChatCompletionResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return openai.ChatCompletionResponse{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+func (c *InMemoryLRUCache) Set(_ context.Context, key string, resp openai.ChatCompletionResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruEntry).value = resp
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	elem := c.order.PushFront(&lruEntry{key: key, value: resp})
+	c.entries[key] = elem
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*lruEntry).key)
+		}
+	}
+}
+
+// assembledChatCompletion reassembles a streamed chat completion into the
+// same shape client.CreateChatCompletion would have returned, so a streamed
+// call can still be written into the response cache once it's done.
+type assembledChatCompletion struct {
+	ID      string
+	Object  string
+	Created int64
+	Model   string
+
+	content map[int]*strings.Builder
+	roles   map[int]string
+	finish  map[int]openai.FinishReason
+
+	// Usage is only populated if the upstream actually sent a usage chunk
+	// (requires stream_options.include_usage); callers should fall back to
+	// an estimate when it's nil.
+	Usage *openai.Usage
+}
+
+func (a *assembledChatCompletion) absorb(chunk openai.ChatCompletionStreamResponse) {
+	if a.content == nil {
+		a.content = make(map[int]*strings.Builder)
+		a.roles = make(map[int]string)
+		a.finish = make(map[int]openai.FinishReason)
+	}
+	if a.ID == "" {
+		a.ID = chunk.ID
+	}
+	if a.Created == 0 {
+		a.Created = chunk.Created
+	}
+	if chunk.Usage != nil {
+		a.Usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		if _, ok := a.content[choice.Index]; !ok {
+			a.content[choice.Index] = &strings.Builder{}
+		}
+		a.content[choice.Index].WriteString(choice.Delta.Content)
+		if choice.Delta.Role != "" {
+			a.roles[choice.Index] = choice.Delta.Role
+		}
+		if choice.FinishReason != "" {
+			a.finish[choice.Index] = choice.FinishReason
+		}
+	}
+}
+
+func (a *assembledChatCompletion) toResponse() openai.ChatCompletionResponse {
+	resp := openai.ChatCompletionResponse{
+		ID:      a.ID,
+		Object:  a.Object,
+		Created: a.Created,
+		Model:   a.Model,
+	}
+
+	indices := make([]int, 0, len(a.content))
+	for idx := range a.content {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+
+	for _, idx := range indices {
+		resp.Choices = append(resp.Choices, openai.ChatCompletionChoice{
+			Index: idx,
+			Message: openai.ChatCompletionMessage{
+				Role:    a.roles[idx],
+				Content: a.content[idx].String(),
+			},
+			FinishReason: a.finish[idx],
+		})
+	}
+
+	if a.Usage != nil {
+		resp.Usage = *a.Usage
+	}
+
+	return resp
+}
+
+// estimatedUsage returns the stream's actual usage if the upstream reported
+// one (via stream_options.include_usage), or a rough word-count estimate
+// otherwise, so usage accounting still has a non-zero number to charge
+// against a key's TPM budget when the upstream doesn't echo real counts.
+func (a *assembledChatCompletion) estimatedUsage(promptText string) (promptTokens, completionTokens int) {
+	if a.Usage != nil {
+		return a.Usage.PromptTokens, a.Usage.CompletionTokens
+	}
+
+	var completion strings.Builder
+	for _, builder := range a.content {
+		completion.WriteString(builder.String())
+	}
+
+	return estimateTokens(promptText), estimateTokens(completion.String())
+}
+
+// estimateTokens applies the same ~4-characters-per-token rule of thumb
+// OpenAI's own docs use, for cases where we don't have a real token count.
+func estimateTokens(s string) int {
+	if len(s) == 0 {
+		return 0
+	}
+	return len(s)/4 + 1
+}
+
+// RedisCache is a ResponseCache backed by Redis, for sharing the cache
+// across proxy replicas.
+type RedisCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewRedisCache(client *redis.Client, ttl time.Duration) *RedisCache {
+	return &RedisCache{client: client, ttl: ttl}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) (openai.ChatCompletionResponse, bool) {
+	data, err := c.client.Get(ctx, key).Bytes()
+	if err != nil {
+		return openai.ChatCompletionResponse{}, false
+	}
+
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return openai.ChatCompletionResponse{}, false
+	}
+	return resp, true
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, resp openai.ChatCompletionResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	c.client.Set(ctx, key, data, c.ttl)
+}
+
+const (
+	defaultCacheSize = 1000
+	defaultCacheTTL  = time.Hour
+)
+
+// loadResponseCache picks a ResponseCache backend from the environment:
+// CACHE_BACKEND is "memory" (default), "redis", or "none" to disable caching.
+func loadResponseCache() (ResponseCache, error) {
+	backend := os.Getenv("CACHE_BACKEND")
+	switch backend {
+	case "none":
+		return nil, nil
+
+	case "redis":
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("CACHE_BACKEND=redis requires REDIS_ADDR")
+		}
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		return NewRedisCache(client, defaultCacheTTL), nil
+
+	case "memory", "":
+		return NewInMemoryLRUCache(defaultCacheSize), nil
+
+	default:
+		return nil, fmt.Errorf("unknown CACHE_BACKEND %q", backend)
+	}
+}
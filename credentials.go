@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+)
+
+// cloudPlatformScope is the OAuth scope every credential strategy below
+// requests; it's the one Vertex AI's API surface needs.
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// CredentialConfig selects how the proxy authenticates to Google Cloud.
+type CredentialConfig struct {
+	// Strategy is one of AuthStrategyADC, AuthStrategyWorkloadID, or
+	// AuthStrategyImpersonated.
+	Strategy AuthStrategy
+
+	// ImpersonateServiceAccount is the target service account email, used
+	// only when Strategy is AuthStrategyImpersonated.
+	ImpersonateServiceAccount string
+}
+
+// LoadTokenSource resolves a CredentialConfig into a ready-to-use,
+// concurrency-safe oauth2.TokenSource. The returned source is wrapped in
+// oauth2.ReuseTokenSource, so callers can share it across requests and it
+// will refresh itself lazily as tokens near expiry - no background goroutine
+// or external synchronization required.
+func LoadTokenSource(ctx context.Context, cfg CredentialConfig) (oauth2.TokenSource, error) {
+	switch cfg.Strategy {
+	case AuthStrategyImpersonated:
+		if cfg.ImpersonateServiceAccount == "" {
+			return nil, fmt.Errorf("impersonated credential strategy requires a target service account")
+		}
+		ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: cfg.ImpersonateServiceAccount,
+			Scopes:          []string{cloudPlatformScope},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build impersonated credentials for %s: %w", cfg.ImpersonateServiceAccount, err)
+		}
+		return oauth2.ReuseTokenSource(nil, ts), nil
+
+	case AuthStrategyADC, AuthStrategyWorkloadID, "":
+		// google.FindDefaultCredentials already covers both plain ADC and
+		// workload identity federation: it honors GOOGLE_APPLICATION_CREDENTIALS
+		// (a service-account JSON key, or a WIF external_account config file)
+		// and falls back to the metadata server when running on GCP.
+		creds, err := google.FindDefaultCredentials(ctx, cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get default credentials: %w", err)
+		}
+		return oauth2.ReuseTokenSource(nil, creds.TokenSource), nil
+
+	default:
+		return nil, fmt.Errorf("unknown credential strategy %q", cfg.Strategy)
+	}
+}
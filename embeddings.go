@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"golang.org/x/oauth2"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+)
+
+// EmbeddingAPIRequest mirrors OpenAI's /v1/embeddings request, accepting a single
+// string or a batch of strings for Input.
+type EmbeddingAPIRequest struct {
+	Model string      `json:"model"`
+	Input interface{} `json:"input"`
+}
+
+type EmbeddingObject struct {
+	Object    string    `json:"object"`
+	Embedding []float32 `json:"embedding"`
+	Index     int       `json:"index"`
+}
+
+type EmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+type EmbeddingAPIResponse struct {
+	Object string            `json:"object"`
+	Data   []EmbeddingObject `json:"data"`
+	Model  string            `json:"model"`
+	Usage  EmbeddingUsage    `json:"usage"`
+}
+
+// vertexEmbeddingRequest/Response model Vertex's predict contract for
+// text-embedding-* models: https://cloud.google.com/vertex-ai/docs/generative-ai/embeddings
+type vertexEmbeddingRequest struct {
+	Instances []vertexEmbeddingInstance `json:"instances"`
+}
+
+type vertexEmbeddingInstance struct {
+	Content string `json:"content"`
+}
+
+type vertexEmbeddingResponse struct {
+	Predictions []struct {
+		Embeddings struct {
+			Values     []float32 `json:"values"`
+			Statistics struct {
+				TokenCount int `json:"token_count"`
+			} `json:"statistics"`
+		} `json:"embeddings"`
+	} `json:"predictions"`
+}
+
+func modelNotFoundError(c *gin.Context, modelID string) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error": gin.H{
+			"message": "The model '" + modelID + "' does not exist",
+			"type":    "invalid_request_error",
+			"param":   nil,
+			"code":    "model_not_found",
+		},
+	})
+}
+
+// normalizeEmbeddingInput accepts either a single string or a []interface{} of
+// strings, matching the `input` field OpenAI clients send.
+func normalizeEmbeddingInput(input interface{}) ([]string, error) {
+	switch v := input.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		inputs := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("input must be a string or array of strings")
+			}
+			inputs = append(inputs, s)
+		}
+		return inputs, nil
+	default:
+		return nil, fmt.Errorf("input must be a string or array of strings")
+	}
+}
+
+func (s *Server) handleEmbeddings(c *gin.Context) {
+	var request EmbeddingAPIRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, exists := s.registry.Lookup(request.Model)
+	if !exists || !entry.hasCapability(CapabilityEmbeddings) {
+		modelNotFoundError(c, request.Model)
+		return
+	}
+
+	inputs, err := normalizeEmbeddingInput(request.Input)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	vertexReq := vertexEmbeddingRequest{Instances: make([]vertexEmbeddingInstance, len(inputs))}
+	for i, input := range inputs {
+		vertexReq.Instances[i] = vertexEmbeddingInstance{Content: input}
+	}
+
+	body, err := json.Marshal(vertexReq)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	url := fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:predict",
+		entry.Region, s.config.ProjectID, entry.Region, entry.UpstreamModel)
+
+	tokenSource, err := s.tokenSourceForEntry(c.Request.Context(), entry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	httpClient := oauth2.NewClient(c.Request.Context(), tokenSource)
+	httpResp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(httpResp.Body)
+		c.JSON(httpResp.StatusCode, gin.H{"error": fmt.Sprintf("vertex embeddings request failed: %s", errBody)})
+		return
+	}
+
+	var vertexResp vertexEmbeddingResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&vertexResp); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := EmbeddingAPIResponse{
+		Object: "list",
+		Model:  request.Model,
+		Data:   make([]EmbeddingObject, len(vertexResp.Predictions)),
+	}
+	for i, prediction := range vertexResp.Predictions {
+		response.Data[i] = EmbeddingObject{
+			Object:    "embedding",
+			Embedding: prediction.Embeddings.Values,
+			Index:     i,
+		}
+		response.Usage.PromptTokens += prediction.Embeddings.Statistics.TokenCount
+	}
+	response.Usage.TotalTokens = response.Usage.PromptTokens
+	s.recordUsage(c, response.Usage.PromptTokens, 0)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleCompletions implements the legacy /v1/completions endpoint on top of the
+// same credential/token machinery as handleChatCompletions.
+func (s *Server) handleCompletions(c *gin.Context) {
+	var request openai.CompletionRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, exists := s.registry.Lookup(request.Model)
+	if !exists || !entry.hasCapability(CapabilityCompletions) {
+		modelNotFoundError(c, request.Model)
+		return
+	}
+
+	baseURL, err := entry.baseURL(s.config.ProjectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokenSource, err := s.tokenSourceForEntry(c.Request.Context(), entry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	clientConfig := openai.ClientConfig{}
+	clientConfig.BaseURL = baseURL
+	clientConfig.HTTPClient = oauth2.NewClient(c.Request.Context(), tokenSource)
+	client := openai.NewClientWithConfig(clientConfig)
+
+	request.Model = entry.UpstreamModel
+
+	resp, err := client.CreateCompletion(c.Request.Context(), request)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.recordUsage(c, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+	c.JSON(http.StatusOK, resp)
+}
@@ -2,43 +2,80 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/oauth2"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sashabaranov/go-openai"
-	"golang.org/x/oauth2/google"
 )
 
 type Config struct {
 	ProjectID  string
 	Location   string
 	EndpointID string
+
+	// ModelRegistryPath points at the YAML/JSON file describing routable models.
+	ModelRegistryPath string
+
+	// Credential selects how the proxy authenticates to Google Cloud.
+	Credential CredentialConfig
 }
 
 type Server struct {
-	config     Config
-	credential *google.Credentials
-	router     *gin.Engine
+	config      Config
+	tokenSource oauth2.TokenSource
+	registry    *ModelRegistry
+	keyStore    KeyStore
+	rateLimiter *RateLimiter
+	usage       *UsageTracker
+	cache       ResponseCache
+	router      *gin.Engine
+
+	tokenSourcesMu sync.Mutex
+	tokenSources   map[AuthStrategy]oauth2.TokenSource
 }
 
-func NewServer(config Config) *Server {
+// NewServer wires up a Server. cache may be nil, which disables response
+// caching entirely.
+func NewServer(config Config, registry *ModelRegistry, keyStore KeyStore, cache ResponseCache) *Server {
 	return &Server{
-		config: config,
-		router: gin.Default(),
+		config:       config,
+		registry:     registry,
+		keyStore:     keyStore,
+		rateLimiter:  NewRateLimiter(),
+		usage:        NewUsageTracker(),
+		cache:        cache,
+		router:       gin.Default(),
+		tokenSources: make(map[AuthStrategy]oauth2.TokenSource),
 	}
 }
 
+// Capability names advertised on /v1/models and checked by the chat, completions and embeddings handlers.
+const (
+	CapabilityChat        = "chat"
+	CapabilityEmbeddings  = "embeddings"
+	CapabilityCompletions = "completions"
+)
+
 type ModelObject struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	OwnedBy string `json:"owned_by"`
+	ID           string   `json:"id"`
+	Object       string   `json:"object"`
+	Created      int64    `json:"created"`
+	OwnedBy      string   `json:"owned_by"`
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 type ModelList struct {
@@ -46,47 +83,23 @@ type ModelList struct {
 	Data   []ModelObject `json:"data"`
 }
 
-// Define available models as a package-level constant
-var AvailableModels = map[string]ModelObject{
-	"google/gemini-2.0-flash-001": {
-		ID:      "google/gemini-2.0-flash-001",
-		Object:  "model",
-		Created: 1706745600, // February 1, 2024
-		OwnedBy: "google",
-	},
-}
-
-// Simplified handlers using the single source of truth
 func (s *Server) handleListModels(c *gin.Context) {
-	modelList := ModelList{
+	c.JSON(http.StatusOK, ModelList{
 		Object: "list",
-		Data:   make([]ModelObject, 0, len(AvailableModels)),
-	}
-
-	for _, model := range AvailableModels {
-		modelList.Data = append(modelList.Data, model)
-	}
-
-	c.JSON(http.StatusOK, modelList)
+		Data:   s.registry.List(),
+	})
 }
 
 func (s *Server) handleRetrieveModel(c *gin.Context) {
 	modelID := c.Param("model")
 
-	model, exists := AvailableModels[modelID]
+	entry, exists := s.registry.Lookup(modelID)
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": gin.H{
-				"message": "The model '" + modelID + "' does not exist",
-				"type":    "invalid_request_error",
-				"param":   nil,
-				"code":    "model_not_found",
-			},
-		})
+		modelNotFoundError(c, modelID)
 		return
 	}
 
-	c.JSON(http.StatusOK, model)
+	c.JSON(http.StatusOK, entry.toModelObject())
 }
 
 func (s *Server) setupRoutes() {
@@ -99,35 +112,62 @@ func (s *Server) setupRoutes() {
 		MaxAge:           12 * time.Hour,
 	}))
 
-	s.router.POST("/v1/chat/completions", s.handleChatCompletions)
+	authorized := s.router.Group("/", s.apiKeyAuthMiddleware())
+	authorized.POST("/v1/chat/completions", s.handleChatCompletions)
+	authorized.POST("/v1/embeddings", s.handleEmbeddings)
+	authorized.POST("/v1/completions", s.handleCompletions)
+	authorized.GET("/admin/usage", s.handleAdminUsage)
+
 	s.router.OPTIONS("/v1/chat/completions", s.handleOptions)
+	s.router.OPTIONS("/v1/embeddings", s.handleOptions)
+	s.router.OPTIONS("/v1/completions", s.handleOptions)
 	s.router.GET("/v1/models", s.handleListModels)
 	s.router.GET("/v1/models/:model", s.handleRetrieveModel)
+	s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	// Add more OpenAI-compatible endpoints as needed
 }
 
-func (s *Server) refreshCredentials(ctx context.Context) error {
-	creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
+// initCredentials resolves s.config.Credential into a token source once at
+// startup. The oauth2.ReuseTokenSource it returns refreshes itself lazily
+// and is safe for concurrent use, so s.tokenSource is never reassigned after
+// this call - no background refresh goroutine and no locking needed around
+// reads of it.
+func (s *Server) initCredentials(ctx context.Context) error {
+	tokenSource, err := LoadTokenSource(ctx, s.config.Credential)
 	if err != nil {
-		return fmt.Errorf("failed to get credentials: %v", err)
+		return err
 	}
+	s.tokenSource = tokenSource
+	return nil
+}
 
-	token, err := creds.TokenSource.Token()
-	if err != nil {
-		return fmt.Errorf("failed to get token: %v", err)
+// tokenSourceForEntry returns the token source a request to entry should
+// authenticate with. Most entries share the server's global AuthStrategy and
+// get s.tokenSource directly; an entry whose AuthStrategy differs gets its
+// own ReuseTokenSource, built once and cached, using the same
+// ImpersonateServiceAccount configured for the process (per-entry
+// impersonation targets aren't modeled yet).
+func (s *Server) tokenSourceForEntry(ctx context.Context, entry ModelRegistryEntry) (oauth2.TokenSource, error) {
+	if entry.AuthStrategy == "" || entry.AuthStrategy == s.config.Credential.Strategy {
+		return s.tokenSource, nil
 	}
 
-	s.credential = creds
+	s.tokenSourcesMu.Lock()
+	defer s.tokenSourcesMu.Unlock()
 
-	// Schedule next refresh before token expires
-	go func() {
-		time.Sleep(time.Until(token.Expiry.Add(-5 * time.Minute)))
-		if err := s.refreshCredentials(ctx); err != nil {
-			log.Printf("Failed to refresh credentials: %v", err)
-		}
-	}()
+	if ts, ok := s.tokenSources[entry.AuthStrategy]; ok {
+		return ts, nil
+	}
 
-	return nil
+	ts, err := LoadTokenSource(ctx, CredentialConfig{
+		Strategy:                  entry.AuthStrategy,
+		ImpersonateServiceAccount: s.config.Credential.ImpersonateServiceAccount,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve auth strategy %q for model %q: %w", entry.AuthStrategy, entry.ID, err)
+	}
+	s.tokenSources[entry.AuthStrategy] = ts
+	return ts, nil
 }
 
 func (s *Server) handleOptions(c *gin.Context) {
@@ -135,22 +175,61 @@ func (s *Server) handleOptions(c *gin.Context) {
 }
 
 func (s *Server) handleChatCompletions(c *gin.Context) {
+	start := time.Now()
+
 	var request openai.ChatCompletionRequest
 	if err := c.BindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	entry, exists := s.registry.Lookup(request.Model)
+	if !exists || !entry.hasCapability(CapabilityChat) {
+		modelNotFoundError(c, request.Model)
+		return
+	}
+
+	useCache := s.cache != nil && shouldUseCache(c.GetHeader("X-Cache"))
+	var key string
+	if useCache {
+		key = cacheKey(request)
+		if !request.Stream {
+			if cached, hit := s.cache.Get(c.Request.Context(), key); hit {
+				c.Header("X-Cache", "HIT")
+				c.Header("X-Cache-Latency-Ms", fmt.Sprintf("%d", time.Since(start).Milliseconds()))
+				c.Header("X-Cache-Saved-Tokens", fmt.Sprintf("%d", cached.Usage.TotalTokens))
+				c.JSON(http.StatusOK, cached)
+				return
+			}
+		}
+	}
+
+	baseURL, err := entry.baseURL(s.config.ProjectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokenSource, err := s.tokenSourceForEntry(c.Request.Context(), entry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	clientConfig := openai.ClientConfig{}
-	clientConfig.BaseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1beta1/projects/%s/locations/%s/endpoints/%s",
-		s.config.Location, s.config.ProjectID, s.config.Location, s.config.EndpointID)
-	httpClient := oauth2.NewClient(c.Request.Context(), s.credential.TokenSource)
+	clientConfig.BaseURL = baseURL
+	httpClient := oauth2.NewClient(c.Request.Context(), tokenSource)
 
 	// Update the client configuration to use Google credentials
 	clientConfig.HTTPClient = httpClient
 	client := openai.NewClientWithConfig(clientConfig)
 
-	request.Model = "google/gemini-2.0-flash-001"
+	request.Model = entry.UpstreamModel
+
+	if request.Stream {
+		s.handleChatCompletionsStream(c, client, request, useCache, key)
+		return
+	}
 
 	resp, err := client.CreateChatCompletion(c.Request.Context(), request)
 	if err != nil {
@@ -158,9 +237,81 @@ func (s *Server) handleChatCompletions(c *gin.Context) {
 		return
 	}
 
+	s.recordUsage(c, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+	if useCache {
+		s.cache.Set(c.Request.Context(), key, resp)
+		c.Header("X-Cache", "MISS")
+	}
 	c.JSON(http.StatusOK, resp)
 }
 
+// handleChatCompletionsStream relays Vertex's streamed chat completion chunks to the
+// client as an OpenAI-compatible text/event-stream, aborting the upstream call if the
+// client disconnects. The stream is always reassembled so usage can be recorded once
+// it completes, matching the other handlers; when useCache is set, the reassembled
+// response is also stored under key. Only non-streaming requests read the cache, so a
+// later non-streaming request with the same cache key can be served from it.
+func (s *Server) handleChatCompletionsStream(c *gin.Context, client *openai.Client, request openai.ChatCompletionRequest, useCache bool, key string) {
+	ctx := c.Request.Context()
+
+	// Ask the upstream to echo real token usage in the final chunk so this
+	// path can charge TPM and record /admin/usage the same as non-streaming
+	// requests; promptText backs the estimate used as a fallback when the
+	// upstream doesn't honor stream_options.
+	if request.StreamOptions == nil {
+		request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	var promptText strings.Builder
+	for _, message := range request.Messages {
+		promptText.WriteString(message.Content)
+	}
+
+	stream, err := client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer stream.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	assembled := assembledChatCompletion{Object: "chat.completion", Model: request.Model}
+
+	c.Stream(func(w io.Writer) bool {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			promptTokens, completionTokens := assembled.estimatedUsage(promptText.String())
+			s.recordUsage(c, promptTokens, completionTokens)
+			if useCache {
+				s.cache.Set(ctx, key, assembled.toResponse())
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return false
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// Client disconnected; nothing left to relay upstream.
+				return false
+			}
+			log.Printf("chat completion stream error: %v", err)
+			return false
+		}
+
+		assembled.absorb(response)
+
+		payload, err := json.Marshal(response)
+		if err != nil {
+			log.Printf("failed to marshal stream chunk: %v", err)
+			return false
+		}
+
+		fmt.Fprintf(w, "data: %s\n\n", payload)
+		return true
+	})
+}
+
 type tokenTransport struct {
 	token func() (*oauth2.Token, error)
 }
@@ -177,16 +328,47 @@ func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 
 func main() {
 	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
+	registryPath := os.Getenv("MODEL_REGISTRY_PATH")
+	if registryPath == "" {
+		registryPath = "config/models.yaml"
+	}
+
+	credentialStrategy := AuthStrategy(os.Getenv("CREDENTIAL_STRATEGY"))
+	if credentialStrategy == "" {
+		credentialStrategy = AuthStrategyADC
+	}
+
 	config := Config{
-		ProjectID:  projectID,
-		Location:   "us-central1",
-		EndpointID: "openapi", // or your specific endpoint ID
+		ProjectID:         projectID,
+		Location:          "us-central1",
+		EndpointID:        "openapi", // or your specific endpoint ID
+		ModelRegistryPath: registryPath,
+		Credential: CredentialConfig{
+			Strategy:                  credentialStrategy,
+			ImpersonateServiceAccount: os.Getenv("IMPERSONATE_SERVICE_ACCOUNT"),
+		},
+	}
+
+	registry, err := LoadModelRegistry(config.ModelRegistryPath)
+	if err != nil {
+		log.Fatalf("Failed to load model registry: %v", err)
 	}
+	watchForReload(registry)
 
-	server := NewServer(config)
+	keyStore, err := loadKeyStore()
+	if err != nil {
+		log.Fatalf("Failed to load API key store: %v", err)
+	}
+
+	cache, err := loadResponseCache()
+	if err != nil {
+		log.Fatalf("Failed to initialize response cache: %v", err)
+	}
+
+	server := NewServer(config, registry, keyStore, cache)
 
 	ctx := context.Background()
-	if err := server.refreshCredentials(ctx); err != nil {
+	if err := server.initCredentials(ctx); err != nil {
 		log.Fatalf("Failed to initialize credentials: %v", err)
 	}
 
@@ -197,4 +379,21 @@ func main() {
 	}
 }
 
+// watchForReload reloads the model registry from disk whenever the process
+// receives SIGHUP, so operators can add or change models without a restart.
+func watchForReload(registry *ModelRegistry) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		for range sighup {
+			if err := registry.Reload(); err != nil {
+				log.Printf("Failed to reload model registry: %v", err)
+				continue
+			}
+			log.Println("Model registry reloaded")
+		}
+	}()
+}
+
 //https://us-central1-aiplatform.googleapis.com/v1beta1/projects/ca-observability-gemi-dev-i8pt/locations/us-central1/endpoints/openapi
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Provider identifies which upstream a ModelRegistryEntry is served from.
+type Provider string
+
+const (
+	ProviderVertex            Provider = "vertex"
+	ProviderAnthropicOnVertex Provider = "anthropic-on-vertex"
+	ProviderOpenAIPassthrough Provider = "openai-passthrough"
+)
+
+// AuthStrategy identifies how the proxy authenticates to an entry's upstream.
+// An entry that leaves this unset, or sets it to the server's global
+// CREDENTIAL_STRATEGY, shares the server's token source; any other value is
+// resolved to its own token source by Server.tokenSourceForEntry. See
+// LoadTokenSource for how each value is resolved to credentials.
+type AuthStrategy string
+
+const (
+	AuthStrategyADC          AuthStrategy = "adc"
+	AuthStrategyWorkloadID   AuthStrategy = "workload-identity"
+	AuthStrategyImpersonated AuthStrategy = "impersonated"
+)
+
+// ModelRegistryEntry describes one routable model: which provider serves it, the
+// upstream model name to send, where it lives, and how to authenticate to it.
+type ModelRegistryEntry struct {
+	ID            string       `json:"id" yaml:"id"`
+	Provider      Provider     `json:"provider" yaml:"provider"`
+	UpstreamModel string       `json:"upstream_model" yaml:"upstream_model"`
+	Region        string       `json:"region" yaml:"region"`
+	EndpointID    string       `json:"endpoint_id" yaml:"endpoint_id"`
+	AuthStrategy  AuthStrategy `json:"auth_strategy" yaml:"auth_strategy"`
+	Capabilities  []string     `json:"capabilities" yaml:"capabilities"`
+	OwnedBy       string       `json:"owned_by" yaml:"owned_by"`
+	Created       int64        `json:"created" yaml:"created"`
+}
+
+func (e ModelRegistryEntry) hasCapability(capability string) bool {
+	for _, c := range e.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func (e ModelRegistryEntry) toModelObject() ModelObject {
+	return ModelObject{
+		ID:           e.ID,
+		Object:       "model",
+		Created:      e.Created,
+		OwnedBy:      e.OwnedBy,
+		Capabilities: e.Capabilities,
+	}
+}
+
+// baseURL returns the upstream BaseURL to point an openai.ClientConfig at for this
+// entry, given the owning GCP project. Only providers that speak the OpenAI
+// chat-completions wire format belong here: handleChatCompletions and
+// handleCompletions build an openai.Client around whatever this returns and
+// call its OpenAI-shaped methods directly.
+func (e ModelRegistryEntry) baseURL(projectID string) (string, error) {
+	switch e.Provider {
+	case ProviderVertex, ProviderOpenAIPassthrough:
+		return fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1beta1/projects/%s/locations/%s/endpoints/%s",
+			e.Region, projectID, e.Region, e.EndpointID), nil
+	case ProviderAnthropicOnVertex:
+		// Anthropic-on-Vertex serves the native Messages API on a
+		// :rawPredict/:streamRawPredict suffix, not OpenAI's chat-completions
+		// wire format, so it can't be dispatched through openai.Client like
+		// the other providers. Reject it here rather than building a URL that
+		// would silently receive wrong-shaped requests.
+		return "", fmt.Errorf("provider %q for model %q is not yet supported by the OpenAI-compatible handlers", e.Provider, e.ID)
+	default:
+		return "", fmt.Errorf("unknown provider %q for model %q", e.Provider, e.ID)
+	}
+}
+
+type modelRegistryFile struct {
+	Models []ModelRegistryEntry `json:"models" yaml:"models"`
+}
+
+// ModelRegistry is the routable set of models, loaded from a YAML/JSON config file
+// and safe to reload in place (e.g. on SIGHUP) while requests are in flight.
+type ModelRegistry struct {
+	path string
+
+	mu      sync.RWMutex
+	entries map[string]ModelRegistryEntry
+}
+
+// LoadModelRegistry reads and parses the registry config at path. There is no
+// file-extension check: the config is always parsed as YAML, which is a
+// superset of JSON, so a .json file parses the same way.
+func LoadModelRegistry(path string) (*ModelRegistry, error) {
+	r := &ModelRegistry{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the registry config from disk and atomically swaps it in,
+// leaving the previous entries in place if parsing fails.
+func (r *ModelRegistry) Reload() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to read model registry %s: %w", r.path, err)
+	}
+
+	var file modelRegistryFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse model registry %s: %w", r.path, err)
+	}
+
+	entries := make(map[string]ModelRegistryEntry, len(file.Models))
+	for _, entry := range file.Models {
+		entries[entry.ID] = entry
+	}
+
+	r.mu.Lock()
+	r.entries = entries
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Lookup returns the registry entry for modelID, if any.
+func (r *ModelRegistry) Lookup(modelID string) (ModelRegistryEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.entries[modelID]
+	return entry, ok
+}
+
+// List returns all registered entries as OpenAI-compatible ModelObjects, for
+// /v1/models.
+func (r *ModelRegistry) List() []ModelObject {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	models := make([]ModelObject, 0, len(r.entries))
+	for _, entry := range r.entries {
+		models = append(models, entry.toModelObject())
+	}
+	return models
+}